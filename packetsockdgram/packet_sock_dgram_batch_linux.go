@@ -0,0 +1,162 @@
+package packetsockdgram
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Message holds a pre-allocated buffer for a single datagram so that a
+// receive loop can reuse the same backing arrays across calls to
+// ReadBatch instead of allocating per packet.
+type Message struct {
+	Buffers [][]byte
+	N       int
+	IpH     *IpHeader
+	UdpH    *UdpHeader
+	Payload []byte
+	Err     error
+}
+
+// NewMessages allocates n Messages, each with a single buffer of size
+// buflen, ready to be passed to ReadBatch.
+func NewMessages(n, buflen int) []Message {
+	msgs := make([]Message, n)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, buflen)}
+	}
+	return msgs
+}
+
+// mmsghdr mirrors struct mmsghdr from <bits/socket.h>: a msghdr plus the
+// byte count the kernel filled in.
+type mmsghdr struct {
+	Hdr syscall.Msghdr
+	Len uint32
+}
+
+const sysRECVMMSG = syscall.SYS_RECVMMSG
+
+// ReadBatch pulls up to len(msgs) datagrams from the AF_PACKET socket in
+// a single recvmmsg(2) call, parsing each into IpHeader/UdpHeader/payload
+// in place on the corresponding Message. It returns the number of
+// messages filled in. readFromIpv4/readFromIpv6 remain the batch-size-1
+// special case, used by Readfrom_.
+func (c *Conn) ReadBatch(msgs []Message) (int, error) {
+	if !c.handler.ok() || c.rawConn == nil {
+		return 0, ErrInvalidConn
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	hdrs := make([]mmsghdr, len(msgs))
+	iovecs := make([]syscall.Iovec, len(msgs))
+	for i := range msgs {
+		b := msgs[i].Buffers[0]
+		iovecs[i].Base = &b[0]
+		iovecs[i].SetLen(len(b))
+		hdrs[i].Hdr.Iov = &iovecs[i]
+		hdrs[i].Hdr.Iovlen = 1
+	}
+
+	// c.fd is non-blocking (see packet_sock_dgram_deadline_linux.go), so
+	// recvmmsg returns EAGAIN immediately on an idle socket instead of
+	// waiting for a packet; go through c.rawConn like Readfrom_ does so
+	// this call actually blocks (honoring SetReadDeadline) until at
+	// least one datagram, or MSG_WAITFORONE's worth, is ready.
+	var n uintptr
+	var errno syscall.Errno
+	readErr := c.rawConn.Read(func(fd uintptr) bool {
+		n, _, errno = syscall.Syscall6(sysRECVMMSG, fd,
+			uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)),
+			uintptr(syscall.MSG_TRUNC|syscall.MSG_WAITFORONE), 0, 0)
+		return errno != syscall.EAGAIN
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+	if errno != 0 {
+		return 0, errno
+	}
+
+	count := int(n)
+	for i := 0; i < count; i++ {
+		buf := msgs[i].Buffers[0]
+		b := buf[:clampRecvLen(int(hdrs[i].Len), len(buf))]
+		msgs[i].N = int(hdrs[i].Len)
+		if c.isIpv4 {
+			msgs[i].IpH, msgs[i].UdpH, msgs[i].Payload, msgs[i].Err = c.parseIpv4(b)
+		} else {
+			msgs[i].IpH, msgs[i].UdpH, msgs[i].Payload, msgs[i].Err = c.parseIpv6(b)
+		}
+	}
+
+	return count, nil
+}
+
+// WriteBatch sends len(msgs) datagrams in a single sendmmsg(2) call, the
+// send-side counterpart of ReadBatch. Each Message's IpH/UdpH/Payload
+// are marshaled exactly as WriteRaw would, then handed to the kernel
+// together targeting c's bound interface.
+func (c *Conn) WriteBatch(msgs []Message) (int, error) {
+	if !c.handler.ok() {
+		return 0, ErrInvalidConn
+	}
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	sa := c.sockaddrLL()
+	saBytes, err := marshalSockaddrLL(sa)
+	if err != nil {
+		return 0, err
+	}
+
+	hdrs := make([]mmsghdr, len(msgs))
+	iovecs := make([]syscall.Iovec, len(msgs))
+	bufs := make([][]byte, len(msgs))
+	for i := range msgs {
+		if msgs[i].IpH == nil || msgs[i].UdpH == nil {
+			return i, ErrNilHeader
+		}
+		b, err := marshalDatagram(c.isIpv4, msgs[i].IpH, msgs[i].UdpH, msgs[i].Payload)
+		if err != nil {
+			return i, err
+		}
+		bufs[i] = b
+
+		iovecs[i].Base = &bufs[i][0]
+		iovecs[i].SetLen(len(bufs[i]))
+		hdrs[i].Hdr.Iov = &iovecs[i]
+		hdrs[i].Hdr.Iovlen = 1
+		hdrs[i].Hdr.Name = &saBytes[0]
+		hdrs[i].Hdr.Namelen = uint32(len(saBytes))
+	}
+
+	n, _, errno := syscall.Syscall6(sysSENDMMSG, uintptr(c.fd),
+		uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), 0, 0, 0)
+	if errno != 0 {
+		return int(n), errno
+	}
+
+	return int(n), nil
+}
+
+// marshalSockaddrLL packs a syscall.SockaddrLinklayer into the raw
+// sockaddr_ll bytes needed for the Name field of an mmsghdr, mirroring
+// what the runtime does internally for Sendto.
+func marshalSockaddrLL(sa *syscall.SockaddrLinklayer) ([]byte, error) {
+	raw := syscall.RawSockaddrLinklayer{
+		Family:   syscall.AF_PACKET,
+		Protocol: sa.Protocol,
+		Ifindex:  int32(sa.Ifindex),
+		Hatype:   sa.Hatype,
+		Pkttype:  sa.Pkttype,
+		Halen:    sa.Halen,
+	}
+	copy(raw.Addr[:], sa.Addr[:])
+
+	b := make([]byte, unsafe.Sizeof(raw))
+	copy(b, (*[unsafe.Sizeof(raw)]byte)(unsafe.Pointer(&raw))[:])
+	return b, nil
+}
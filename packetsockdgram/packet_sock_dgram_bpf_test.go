@@ -0,0 +1,68 @@
+package packetsockdgram
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"golang.org/x/net/bpf"
+)
+
+// buildIPv4UDP constructs a minimal (header-only, options-free) IPv4+UDP
+// packet with the given destination port, matching the layout
+// NewUDPPortFilter assumes.
+func buildIPv4UDP(dstPort uint16) []byte {
+	b := make([]byte, 20+8)
+	b[0] = 0x45
+	b[9] = syscall.IPPROTO_UDP
+	binary.BigEndian.PutUint16(b[20+2:20+4], dstPort)
+	return b
+}
+
+func TestNewUDPPortFilter(t *testing.T) {
+	raw := NewUDPPortFilter(9000, true)
+
+	// bpf.NewVM type-switches on the last instruction, which only matches
+	// RetA/RetConstant; a bare RawInstruction (even one that assembles to
+	// the same opcode) doesn't satisfy it, so disassemble back to the
+	// typed instructions NewUDPPortFilter built before handing them to a VM.
+	insns := make([]bpf.Instruction, len(raw))
+	for i, r := range raw {
+		insns[i] = r.Disassemble()
+	}
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		t.Fatalf("bpf.NewVM: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		pkt  []byte
+		want bool
+	}{
+		{"matching UDP port", buildIPv4UDP(9000), true},
+		{"non-matching UDP port", buildIPv4UDP(9001), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			n, err := vm.Run(c.pkt)
+			if err != nil {
+				t.Fatalf("vm.Run: %v", err)
+			}
+			got := n > 0
+			if got != c.want {
+				t.Fatalf("accepted = %v, want %v", got, c.want)
+			}
+		})
+	}
+
+	tcpPkt := buildIPv4UDP(9000)
+	tcpPkt[9] = syscall.IPPROTO_TCP
+	n, err := vm.Run(tcpPkt)
+	if err != nil {
+		t.Fatalf("vm.Run: %v", err)
+	}
+	if n > 0 {
+		t.Fatalf("non-UDP packet with matching port bytes should be rejected, accepted %d bytes", n)
+	}
+}
@@ -0,0 +1,71 @@
+package packetsockdgram
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// newPollFile wires fd into Go's runtime poller via os.NewFile, so that
+// a blocked Recvfrom is interrupted by Close instead of leaking the
+// calling goroutine, and so SetReadDeadline/ReadfromContext can cancel a
+// pending read. Conn.Close closes fd through the returned *os.File, so
+// callers must not also syscall.Close it.
+func newPollFile(fd int, name string) (*os.File, error) {
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// SetReadDeadline sets the deadline for future Readfrom_ calls. A zero
+// value for t disables the deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if !c.handler.ok() || c.file == nil {
+		return ErrInvalidConn
+	}
+	return c.file.SetReadDeadline(t)
+}
+
+// ReadfromContext behaves like Readfrom_ but returns ctx.Err() once ctx
+// is done, instead of blocking forever on a socket with no traffic.
+func (c *Conn) ReadfromContext(ctx context.Context, b []byte) (n int, h *IpHeader, uh *UdpHeader, p []byte, err error) {
+	if !c.handler.ok() || c.file == nil {
+		return 0, nil, nil, nil, ErrInvalidConn
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		if err := c.file.SetReadDeadline(dl); err != nil {
+			return 0, nil, nil, nil, err
+		}
+	}
+
+	if ctx.Done() != nil {
+		// Always restore the deadline once this call returns, whether or
+		// not ctx carries a Deadline() — cancellation forces it to
+		// aLongTimeAgo below, and leaving that in place would fail every
+		// later Readfrom_/ReadfromContext on this Conn with a timeout.
+		defer c.file.SetReadDeadline(time.Time{})
+
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.file.SetReadDeadline(aLongTimeAgo)
+			case <-done:
+			}
+		}()
+	}
+
+	n, h, uh, p, err = c.Readfrom_(b)
+	if err != nil && ctx.Err() != nil {
+		return n, h, uh, p, ctx.Err()
+	}
+	return n, h, uh, p, err
+}
+
+// aLongTimeAgo is used to force an immediate read timeout, the same
+// trick net.Conn implementations use to unblock a pending I/O call.
+var aLongTimeAgo = time.Unix(1, 0)
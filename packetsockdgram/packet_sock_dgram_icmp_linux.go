@@ -0,0 +1,302 @@
+package packetsockdgram
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const IcmpHeaderLen = 8
+
+// IcmpHeader is the common ICMPv4 (RFC 792) / ICMPv6 (RFC 4443) header:
+// an 8-bit type and code, a 16-bit checksum, and 4 bytes whose meaning
+// depends on Type (e.g. identifier/sequence for echo request/reply).
+type IcmpHeader struct {
+	Type     uint8
+	Code     uint8
+	Checksum int
+	Rest     [4]byte
+}
+
+func (h *IcmpHeader) String() string {
+	if h == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("type=%d code=%d checksum=%#x", h.Type, h.Code, h.Checksum)
+}
+
+// ParseICMPv4Header parses the RFC 792 header at the start of b.
+func ParseICMPv4Header(b []byte) (*IcmpHeader, error) {
+	return parseICMPHeader(b)
+}
+
+// ParseICMPv6Header parses the RFC 4443 header at the start of b. The
+// wire layout is identical to ICMPv4's; the meaning of Rest differs per
+// Type and is left to the caller to interpret.
+func ParseICMPv6Header(b []byte) (*IcmpHeader, error) {
+	return parseICMPHeader(b)
+}
+
+func parseICMPHeader(b []byte) (*IcmpHeader, error) {
+	if b == nil {
+		return nil, ErrNilHeader
+	}
+	if len(b) < IcmpHeaderLen {
+		return nil, ErrHeaderTooShort
+	}
+	h := &IcmpHeader{
+		Type:     b[0],
+		Code:     b[1],
+		Checksum: int(binary.BigEndian.Uint16(b[2:4])),
+	}
+	copy(h.Rest[:], b[4:8])
+	return h, nil
+}
+
+// ===================
+// IPv4 fragment reassembly
+
+// fragKey identifies the datagram a fragment belongs to, per RFC 791:
+// fragments share a (source, destination, protocol, identification)
+// tuple.
+type fragKey struct {
+	src, dst string
+	proto    int
+	id       int
+}
+
+type fragEntry struct {
+	elem     *list.Element  // this entry's node in fragReassembler.lru
+	pieces   map[int][]byte // keyed by fragment offset in bytes
+	total    int            // total datagram length, known once the final fragment arrives
+	lastSeen time.Time
+}
+
+// fragReassembler buffers IPv4 fragments until a full datagram can be
+// reconstructed, bounded by both entry count (via an LRU eviction list)
+// and per-entry age.
+type fragReassembler struct {
+	mu      sync.Mutex
+	entries map[fragKey]*fragEntry
+	lru     *list.List // front = most recently touched
+	maxSize int
+	timeout time.Duration
+}
+
+func newFragReassembler(maxSize int, timeout time.Duration) *fragReassembler {
+	return &fragReassembler{
+		entries: make(map[fragKey]*fragEntry),
+		lru:     list.New(),
+		maxSize: maxSize,
+		timeout: timeout,
+	}
+}
+
+// add records one fragment and returns the reassembled payload once
+// every fragment up to one with MoreFragments=false has been seen, with
+// no gaps. It returns (nil, false) while reassembly is incomplete.
+func (r *fragReassembler) add(h *ipv4.Header, payload []byte) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.evictExpired(now)
+
+	key := fragKey{src: string(h.Src), dst: string(h.Dst), proto: h.Protocol, id: h.ID}
+	e, ok := r.entries[key]
+	if !ok {
+		e = &fragEntry{pieces: make(map[int][]byte)}
+		r.entries[key] = e
+		e.elem = r.lru.PushFront(key)
+		r.evictOverCapacity()
+	} else {
+		r.lru.MoveToFront(e.elem)
+	}
+	e.lastSeen = now
+
+	off := h.FragOff * 8
+	buf := make([]byte, len(payload))
+	copy(buf, payload)
+	e.pieces[off] = buf
+
+	if h.Flags&ipv4.MoreFragments == 0 {
+		e.total = off + len(payload)
+	}
+
+	if e.total == 0 {
+		return nil, false
+	}
+
+	full := make([]byte, e.total)
+	have := 0
+	for pieceOff, piece := range e.pieces {
+		if pieceOff+len(piece) > e.total {
+			continue
+		}
+		copy(full[pieceOff:], piece)
+		have += len(piece)
+	}
+	if have < e.total {
+		return nil, false
+	}
+
+	delete(r.entries, key)
+	r.lru.Remove(e.elem)
+	return full, true
+}
+
+func (r *fragReassembler) evictExpired(now time.Time) {
+	for e := r.lru.Back(); e != nil; {
+		key := e.Value.(fragKey)
+		entry := r.entries[key]
+		if now.Sub(entry.lastSeen) < r.timeout {
+			break
+		}
+		prev := e.Prev()
+		delete(r.entries, key)
+		r.lru.Remove(e)
+		e = prev
+	}
+}
+
+func (r *fragReassembler) evictOverCapacity() {
+	for r.lru.Len() > r.maxSize {
+		e := r.lru.Back()
+		delete(r.entries, e.Value.(fragKey))
+		r.lru.Remove(e)
+	}
+}
+
+// ===================
+// ETH_P_ALL endpoint
+
+const (
+	defaultFragMaxEntries = 1024
+	defaultFragTimeout    = 30 * time.Second
+)
+
+// NewConnAllProtocols opens an AF_PACKET/SOCK_DGRAM socket bound to
+// ifname with ETH_P_ALL instead of ETH_P_IP/ETH_P_IPV6, so it receives
+// every L3 protocol rather than just UDP-over-IP. Use ReadfromAny to
+// read from the returned Conn; Readfrom_ still assumes UDP and returns
+// ErrNotDestPort or a parse error for anything else.
+func NewConnAllProtocols(sa syscall.Sockaddr, ifname string) (*Conn, error) {
+	isIpv4 := func(sa syscall.Sockaddr) bool {
+		_, ok := sa.(*syscall.SockaddrInet4)
+		return ok
+	}(sa)
+
+	c, err := newConnProto(sa, isIpv4, ifname, syscall.ETH_P_ALL)
+	if err != nil {
+		return nil, err
+	}
+	c.allProtocols = true
+	c.frag = newFragReassembler(defaultFragMaxEntries, defaultFragTimeout)
+	return c, nil
+}
+
+// ReadfromAny reads one frame and dispatches on the IP version and
+// Protocol/NextHeader byte: UDP is parsed into uh, ICMP into ih, and
+// fragmented IPv4 datagrams are buffered until reassembled (returning
+// ok=false for every fragment but the last). c must have been created
+// with NewConnAllProtocols.
+func (c *Conn) ReadfromAny(b []byte) (n int, h *IpHeader, uh *UdpHeader, ih *IcmpHeader, p []byte, ok bool, err error) {
+	if !c.handler.ok() || !c.allProtocols || c.rawConn == nil {
+		return 0, nil, nil, nil, nil, false, ErrInvalidConn
+	}
+
+	// c.fd is non-blocking (see packet_sock_dgram_deadline_linux.go), so
+	// route the read through c.rawConn like Readfrom_ does instead of
+	// calling Recvfrom directly, or an idle socket would return EAGAIN
+	// immediately instead of waiting for a packet.
+	readErr := c.rawConn.Read(func(fd uintptr) bool {
+		n, _, err = syscall.Recvfrom(int(fd), b, syscall.MSG_TRUNC)
+		return err != syscall.EAGAIN
+	})
+	if readErr != nil {
+		return 0, nil, nil, nil, nil, false, readErr
+	}
+	if err != nil {
+		return 0, nil, nil, nil, nil, false, err
+	}
+	buf := b[:clampRecvLen(n, len(b))]
+	if len(buf) == 0 {
+		return n, nil, nil, nil, nil, false, ErrHeaderTooShort
+	}
+
+	switch buf[0] >> 4 {
+	case 4:
+		return c.readAnyIpv4(n, buf)
+	case 6:
+		return c.readAnyIpv6(n, buf)
+	default:
+		return n, nil, nil, nil, nil, false, ErrNotIpv4
+	}
+}
+
+func (c *Conn) readAnyIpv4(n int, buf []byte) (rn int, h *IpHeader, uh *UdpHeader, ih *IcmpHeader, p []byte, ok bool, err error) {
+	ipv4h, err := ipv4.ParseHeader(buf)
+	if err != nil {
+		return n, nil, nil, nil, nil, false, err
+	}
+	h = &IpHeader{Ipv4Header: ipv4h, Protocol: ipv4h.Protocol}
+
+	payload := buf[ipv4h.Len:]
+	if ipv4h.Flags&ipv4.MoreFragments != 0 || ipv4h.FragOff != 0 {
+		full, done := c.frag.add(ipv4h, payload)
+		if !done {
+			return n, h, nil, nil, nil, false, nil
+		}
+		payload = full
+	}
+
+	switch ipv4h.Protocol {
+	case syscall.IPPROTO_UDP:
+		uh, err = ParseUDPHeader(payload)
+		if err != nil {
+			return n, h, nil, nil, nil, false, err
+		}
+		return n, h, uh, nil, payload[UDPHeaderLen:], true, nil
+	case syscall.IPPROTO_ICMP:
+		ih, err = ParseICMPv4Header(payload)
+		if err != nil {
+			return n, h, nil, nil, nil, false, err
+		}
+		return n, h, nil, ih, payload[IcmpHeaderLen:], true, nil
+	default:
+		return n, h, nil, nil, payload, true, nil
+	}
+}
+
+func (c *Conn) readAnyIpv6(n int, buf []byte) (rn int, h *IpHeader, uh *UdpHeader, ih *IcmpHeader, p []byte, ok bool, err error) {
+	ipv6h, err := ipv6.ParseHeader(buf)
+	if err != nil {
+		return n, nil, nil, nil, nil, false, err
+	}
+	h = &IpHeader{Ipv6Header: ipv6h, Protocol: ipv6h.NextHeader}
+
+	payload := buf[ipv6.HeaderLen:]
+
+	switch ipv6h.NextHeader {
+	case syscall.IPPROTO_UDP:
+		uh, err = ParseUDPHeader(payload)
+		if err != nil {
+			return n, h, nil, nil, nil, false, err
+		}
+		return n, h, uh, nil, payload[UDPHeaderLen:], true, nil
+	case syscall.IPPROTO_ICMPV6:
+		ih, err = ParseICMPv6Header(payload)
+		if err != nil {
+			return n, h, nil, nil, nil, false, err
+		}
+		return n, h, nil, ih, payload[IcmpHeaderLen:], true, nil
+	default:
+		return n, h, nil, nil, payload, true, nil
+	}
+}
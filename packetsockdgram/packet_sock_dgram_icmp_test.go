@@ -0,0 +1,122 @@
+package packetsockdgram
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestParseICMPv4Header(t *testing.T) {
+	b := []byte{8, 0, 0xf7, 0xfd, 0x00, 0x01, 0x00, 0x02}
+	h, err := ParseICMPv4Header(b)
+	if err != nil {
+		t.Fatalf("ParseICMPv4Header: %v", err)
+	}
+	if h.Type != 8 || h.Code != 0 || h.Checksum != 0xf7fd {
+		t.Fatalf("got %+v", h)
+	}
+
+	if _, err := ParseICMPv4Header(b[:4]); err != ErrHeaderTooShort {
+		t.Fatalf("short buffer: got err=%v, want ErrHeaderTooShort", err)
+	}
+}
+
+func frag(src, dst net.IP, id, fragOff int, more bool, payload []byte) (*ipv4.Header, []byte) {
+	flags := ipv4.HeaderFlags(0)
+	if more {
+		flags = ipv4.MoreFragments
+	}
+	return &ipv4.Header{Src: src, Dst: dst, ID: id, FragOff: fragOff, Flags: flags}, payload
+}
+
+func TestFragReassemblerInOrder(t *testing.T) {
+	r := newFragReassembler(defaultFragMaxEntries, defaultFragTimeout)
+	src, dst := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	// Every fragment but the last must be a multiple of 8 bytes, since
+	// FragOff is in 8-byte units (RFC 791).
+	first := []byte("ABCDEFGH") // 8 bytes
+	h1, p1 := frag(src, dst, 42, 0, true, first)
+	if _, done := r.add(h1, p1); done {
+		t.Fatalf("first fragment alone should not complete reassembly")
+	}
+
+	h2, p2 := frag(src, dst, 42, len(first)/8, false, []byte("world!"))
+	full, done := r.add(h2, p2)
+	if !done {
+		t.Fatalf("final fragment should complete reassembly")
+	}
+	if got := string(full); got != "ABCDEFGHworld!" {
+		t.Fatalf("reassembled = %q, want %q", got, "ABCDEFGHworld!")
+	}
+}
+
+func TestFragReassemblerOutOfOrder(t *testing.T) {
+	r := newFragReassembler(defaultFragMaxEntries, defaultFragTimeout)
+	src, dst := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	first := []byte("0123456789012345") // 16 bytes, a multiple of 8
+	h2, p2 := frag(src, dst, 7, len(first)/8, false, []byte("tail"))
+	if _, done := r.add(h2, p2); done {
+		t.Fatalf("final fragment arriving first should still wait on the gap")
+	}
+
+	h1, p1 := frag(src, dst, 7, 0, true, first)
+	full, done := r.add(h1, p1)
+	if !done {
+		t.Fatalf("reassembly should complete once the gap is filled")
+	}
+	want := string(first) + "tail"
+	if got := string(full); got != want {
+		t.Fatalf("reassembled = %q, want %q", got, want)
+	}
+}
+
+func TestFragReassemblerDistinctDatagramsDontMix(t *testing.T) {
+	r := newFragReassembler(defaultFragMaxEntries, defaultFragTimeout)
+	src, dst := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	hA, pA := frag(src, dst, 1, 0, true, []byte("AAAAAAAA"))
+	hB, pB := frag(src, dst, 2, 0, true, []byte("BBBBBBBB"))
+	r.add(hA, pA)
+	r.add(hB, pB)
+
+	if got := len(r.entries); got != 2 {
+		t.Fatalf("len(entries) = %d, want 2 (distinct fragment IDs must not merge)", got)
+	}
+}
+
+func TestFragReassemblerExpiresStaleEntries(t *testing.T) {
+	r := newFragReassembler(defaultFragMaxEntries, time.Nanosecond)
+	src, dst := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	h, p := frag(src, dst, 1, 0, true, []byte("AAAAAAAA"))
+	r.add(h, p)
+	time.Sleep(time.Millisecond)
+
+	h2, p2 := frag(src, dst, 2, 0, true, []byte("BBBBBBBB"))
+	r.add(h2, p2)
+
+	if got := len(r.entries); got != 1 {
+		t.Fatalf("len(entries) = %d, want 1 (stale entry should have been evicted)", got)
+	}
+}
+
+func TestFragReassemblerEvictsOverCapacity(t *testing.T) {
+	r := newFragReassembler(1, defaultFragTimeout)
+	src, dst := net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)
+
+	h1, p1 := frag(src, dst, 1, 0, true, []byte("AAAAAAAA"))
+	r.add(h1, p1)
+	h2, p2 := frag(src, dst, 2, 0, true, []byte("BBBBBBBB"))
+	r.add(h2, p2)
+
+	if got := len(r.entries); got != 1 {
+		t.Fatalf("len(entries) = %d, want 1 (maxSize=1 should evict the oldest)", got)
+	}
+	if _, ok := r.entries[fragKey{src: string(src), dst: string(dst), id: 1}]; ok {
+		t.Fatalf("the older entry (id=1) should have been evicted, not the newer one")
+	}
+}
@@ -0,0 +1,265 @@
+package packetsockdgram
+
+import (
+	"errors"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The following are not exposed by the syscall package; values are from
+// <linux/if_packet.h> and <asm-generic/socket.h>.
+const (
+	solPacket     = 263
+	packetRxRing  = 5
+	packetVersion = 10
+	packetFanout  = 18
+
+	tpacketV3 = 2
+
+	tpStatusKernel = 0
+	tpStatusUser   = 1
+)
+
+// FanoutMode selects how PACKET_FANOUT load-balances frames across the
+// Conns that join the same fanout group.
+type FanoutMode uint16
+
+const (
+	FanoutHash FanoutMode = 0
+	FanoutCPU  FanoutMode = 2
+)
+
+// RingOptions configures a PACKET_RX_RING (TPACKET_V3) ring buffer.
+type RingOptions struct {
+	// BlockSize and BlockNr size the mmap'd ring: BlockSize*BlockNr bytes
+	// total, split into BlockNr blocks the kernel fills independently.
+	// BlockSize must be a multiple of the page size.
+	BlockSize uint32
+	BlockNr   uint32
+	// FrameSize bounds the largest frame captured per packet.
+	FrameSize uint32
+	// BlockTimeout bounds how long the kernel waits before handing back a
+	// partially-filled block (TPACKET_V3's retire_blk_tov).
+	BlockTimeout time.Duration
+
+	// FanoutGroupID, when non-zero, joins this Conn to a PACKET_FANOUT
+	// group shared by other Conns bound to the same interface so frames
+	// are load-balanced across them via Fanout.
+	FanoutGroupID uint16
+	Fanout        FanoutMode
+}
+
+func (o RingOptions) withDefaults() RingOptions {
+	if o.BlockSize == 0 {
+		o.BlockSize = 1 << 20 // 1MiB
+	}
+	if o.BlockNr == 0 {
+		o.BlockNr = 64
+	}
+	if o.FrameSize == 0 {
+		o.FrameSize = 1 << 11 // 2KiB
+	}
+	return o
+}
+
+// ring is the mmap'd TPACKET_V3 ring state walked by Conn.Readfrom_ when
+// a Conn was created with NewRingConn.
+type ring struct {
+	mem       []byte
+	blockSize uint32
+	blockNr   uint32
+
+	curBlock   uint32
+	pktInBlock uint32
+}
+
+// tpacketReq3 mirrors struct tpacket_req3, the PACKET_RX_RING setsockopt
+// argument for TPACKET_V3.
+type tpacketReq3 struct {
+	BlockSize      uint32
+	BlockNr        uint32
+	FrameSize      uint32
+	FrameNr        uint32
+	RetireBlkTov   uint32
+	SizeofPriv     uint32
+	FeatureReqWord uint32
+}
+
+// tpacketBlockDesc mirrors the fixed-size prefix of struct
+// tpacket_block_desc / tpacket_hdr_v1 that this package reads; per-packet
+// walking only needs block_status, num_pkts and offset_to_first_pkt.
+type tpacketBlockDesc struct {
+	Version      uint32
+	OffsetToPriv uint32
+
+	BlockStatus      uint32
+	NumPkts          uint32
+	OffsetToFirstPkt uint32
+	_                uint32 // blk_len
+	_                uint64 // seq_num
+	_, _             [2]uint32
+	_, _             [2]uint32
+}
+
+// tpacket3Hdr mirrors struct tpacket3_hdr, the per-packet header inside a
+// TPACKET_V3 block. Only NextOffset/Snaplen/Mac are read by this package;
+// the hv1 fields are included solely so their offsets (and the struct's
+// overall size) match the kernel's layout.
+type tpacket3Hdr struct {
+	NextOffset uint32
+	Sec        uint32
+	Nsec       uint32
+	Snaplen    uint32
+	Len        uint32
+	Status     uint32
+	Mac        uint16
+	Net        uint16
+	// hv1 (struct tpacket_hdr_variant1)
+	RxHash   uint32
+	VlanTCI  uint32
+	VlanTPID uint16
+	_        uint16
+	_        [8]byte
+}
+
+// NewRingConn opens an AF_PACKET/SOCK_DGRAM socket bound to ifname like
+// NewConn, but backs reads with a mmap'd PACKET_RX_RING (TPACKET_V3)
+// instead of a Recvfrom per packet, so packets are consumed without a
+// syscall per frame. Readfrom_ on the returned Conn walks the ring.
+func NewRingConn(sa syscall.Sockaddr, ifname string, opts RingOptions) (*Conn, error) {
+	opts = opts.withDefaults()
+
+	isIpv4 := func(sa syscall.Sockaddr) bool {
+		_, ok := sa.(*syscall.SockaddrInet4)
+		return ok
+	}(sa)
+
+	c, err := newConn(sa, isIpv4, ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(c.fd),
+		uintptr(solPacket), uintptr(packetVersion),
+		uintptr(unsafe.Pointer(&[]int32{tpacketV3}[0])), 4, 0); errno != 0 {
+		c.Close()
+		return nil, errno
+	}
+
+	req := tpacketReq3{
+		BlockSize:    opts.BlockSize,
+		BlockNr:      opts.BlockNr,
+		FrameSize:    opts.FrameSize,
+		FrameNr:      opts.BlockSize / opts.FrameSize * opts.BlockNr,
+		RetireBlkTov: uint32(opts.BlockTimeout / time.Millisecond),
+	}
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(c.fd),
+		uintptr(solPacket), uintptr(packetRxRing),
+		uintptr(unsafe.Pointer(&req)), unsafe.Sizeof(req), 0); errno != 0 {
+		c.Close()
+		return nil, errno
+	}
+
+	size := int(req.BlockSize) * int(req.BlockNr)
+	mem, err := syscall.Mmap(c.fd, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	c.ring = &ring{mem: mem, blockSize: req.BlockSize, blockNr: req.BlockNr}
+
+	if opts.FanoutGroupID != 0 {
+		if err := c.setFanout(opts.FanoutGroupID, opts.Fanout); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// setFanout joins the PACKET_FANOUT group groupID using mode, so this
+// Conn shares received frames with every other Conn bound to the same
+// interface that joins the same group.
+func (c *Conn) setFanout(groupID uint16, mode FanoutMode) error {
+	arg := int32(groupID) | int32(mode)<<16
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(c.fd),
+		uintptr(solPacket), uintptr(packetFanout),
+		uintptr(unsafe.Pointer(&arg)), 4, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+var errRingClosed = errors.New("ring buffer closed")
+
+// readfromRing waits for the next TPACKET_V3 frame to become available
+// (tp_status & TP_STATUS_USER) and parses it into b, following
+// tp_next_offset within the current block and advancing to the next
+// block via BlockTimeout/poll once the block is exhausted.
+func (c *Conn) readfromRing(b []byte) (n int, h *IpHeader, uh *UdpHeader, p []byte, err error) {
+	r := c.ring
+	if r == nil {
+		return 0, nil, nil, nil, ErrInvalidConn
+	}
+
+	for {
+		blk := r.mem[r.curBlock*r.blockSize : (r.curBlock+1)*r.blockSize]
+		bd := (*tpacketBlockDesc)(unsafe.Pointer(&blk[0]))
+
+		if bd.BlockStatus&tpStatusUser == 0 {
+			// Kernel hasn't handed this block back yet; block on the fd
+			// becoming readable (the poller wakes us on the next
+			// retire_blk_tov or a filled block), then retry.
+			if pollErr := c.pollRing(bd); pollErr != nil {
+				return 0, nil, nil, nil, pollErr
+			}
+			continue
+		}
+
+		if r.pktInBlock >= bd.NumPkts {
+			// Block fully consumed: hand it back to the kernel and move on.
+			bd.BlockStatus = tpStatusKernel
+			r.pktInBlock = 0
+			r.curBlock = (r.curBlock + 1) % r.blockNr
+			continue
+		}
+
+		off := bd.OffsetToFirstPkt
+		for i := uint32(0); i < r.pktInBlock; i++ {
+			hdr := (*tpacket3Hdr)(unsafe.Pointer(&blk[off]))
+			off += hdr.NextOffset
+		}
+		hdr := (*tpacket3Hdr)(unsafe.Pointer(&blk[off]))
+		r.pktInBlock++
+
+		frame := blk[uint32(off)+uint32(hdr.Mac) : uint32(off)+uint32(hdr.Mac)+hdr.Snaplen]
+		n = copy(b, frame)
+
+		if c.isIpv4 {
+			h, uh, p, err = c.parseIpv4(b[:n])
+		} else {
+			h, uh, p, err = c.parseIpv6(b[:n])
+		}
+		return n, h, uh, p, err
+	}
+}
+
+// pollRing blocks until bd's block is handed back to userspace
+// (TP_STATUS_USER), honoring any deadline set via SetReadDeadline. The fd
+// only becomes readable once the kernel retires the block (on
+// RetireBlkTov or a full block), so the poller callback must report that
+// block's status rather than unconditionally claiming readiness, or
+// rawConn.Read returns immediately on an idle interface and readfromRing
+// spins rechecking the same not-yet-ready block.
+func (c *Conn) pollRing(bd *tpacketBlockDesc) error {
+	if c.rawConn == nil {
+		return errRingClosed
+	}
+	return c.rawConn.Read(func(fd uintptr) bool {
+		return bd.BlockStatus&tpStatusUser != 0
+	})
+}
@@ -0,0 +1,7 @@
+package packetsockdgram
+
+// sysSENDMMSG is SYS_sendmmsg. It has no syscall.SYS_SENDMMSG constant on
+// this Go version (unlike SYS_RECVMMSG), so it's hardcoded per-arch here
+// instead of in the arch-agnostic _linux.go files, the same way the
+// runtime's own syscall tables are split per GOARCH.
+const sysSENDMMSG = 307
@@ -0,0 +1,4 @@
+package packetsockdgram
+
+// sysSENDMMSG is SYS_sendmmsg; see packet_sock_dgram_sendmmsg_linux_amd64.go.
+const sysSENDMMSG = 269
@@ -1,6 +1,4 @@
 // 2021/01/09
-//
-//
 package packetsockdgram
 
 import (
@@ -20,7 +18,7 @@ import (
 // ===================
 // helper
 
-//  package err definition
+// package err definition
 var (
 	ErrNotDestPort    = errors.New("not match dest port")
 	ErrInvalidConn    = errors.New("invalid connection")
@@ -36,6 +34,17 @@ func htons(host uint16) uint16 {
 	return (host&0xff)<<8 | (host >> 8)
 }
 
+// clampRecvLen bounds n (a byte count MSG_TRUNC reported for the real
+// datagram, which can exceed the buffer it was received into) to bufLen,
+// so callers can safely slice buf[:clampRecvLen(n, len(buf))] instead of
+// slicing past the backing array on an oversized packet.
+func clampRecvLen(n, bufLen int) int {
+	if n > bufLen {
+		return bufLen
+	}
+	return n
+}
+
 // ===================
 // header
 const (
@@ -45,6 +54,11 @@ const (
 type IpHeader struct {
 	Ipv4Header *ipv4.Header
 	Ipv6Header *ipv6.Header
+
+	// Protocol is the IPv4 protocol / IPv6 next-header byte (e.g.
+	// syscall.IPPROTO_UDP, syscall.IPPROTO_ICMP), set regardless of
+	// which transport, if any, this package goes on to parse.
+	Protocol int
 }
 type UdpHeader struct {
 	SourcePort      int
@@ -87,7 +101,6 @@ type handler struct {
 
 func (c *handler) ok() bool { return c != nil }
 
-//
 func (hdl *handler) readfrom(b []byte) (n int, h *IpHeader, uh *UdpHeader, p []byte, err error) {
 
 	if !hdl.ok() {
@@ -111,8 +124,19 @@ func (hdl *handler) readFromIpv4(b []byte) (n int, iph *IpHeader, uh *UdpHeader,
 		return -1, nil, nil, nil, err
 	}
 
+	iph, uh, p, err = hdl.parseIpv4(b[:clampRecvLen(n, len(b))])
+	return n, iph, uh, p, err
+}
+
+// parseIpv4 parses a single already-received IPv4 datagram out of b. It
+// is the shared tail of readFromIpv4 and ReadBatch, which fills b via
+// recvmmsg(2) instead of Recvfrom.
+func (hdl *handler) parseIpv4(b []byte) (iph *IpHeader, uh *UdpHeader, p []byte, err error) {
+
+	n := len(b)
+
 	if b[0] != 0x45 { // ip version check
-		return n, nil, nil, nil, ErrNotIpv4
+		return nil, nil, nil, ErrNotIpv4
 	}
 
 	// total header len
@@ -120,21 +144,21 @@ func (hdl *handler) readFromIpv4(b []byte) (n int, iph *IpHeader, uh *UdpHeader,
 
 	// header size check
 	if n < totalHeaderlen { // ipv4
-		return n, nil, nil, nil, ErrHeaderTooShort
+		return nil, nil, nil, ErrHeaderTooShort
 	}
 
 	// ipheader
 	ipv4h, err := ipv4.ParseHeader(b)
 	if err != nil {
-		return n, nil, nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	iph = &IpHeader{Ipv4Header: ipv4h}
+	iph = &IpHeader{Ipv4Header: ipv4h, Protocol: ipv4h.Protocol}
 
 	// udp header
 	uh, err = ParseUDPHeader(b[20:])
 	if err != nil {
-		return n, iph, nil, nil, err
+		return iph, nil, nil, err
 	}
 
 	// payload size
@@ -152,10 +176,10 @@ func (hdl *handler) readFromIpv4(b []byte) (n int, iph *IpHeader, uh *UdpHeader,
 	// port
 	rcvport := hdl.sa.(*syscall.SockaddrInet4).Port
 	if rcvport != uh.DestinationPort {
-		return n, iph, uh, pb, ErrNotDestPort
+		return iph, uh, pb, ErrNotDestPort
 	}
 
-	return n, iph, uh, pb, nil
+	return iph, uh, pb, nil
 }
 func (hdl *handler) readFromIpv6(b []byte) (n int, iph *IpHeader, uh *UdpHeader, p []byte, err error) {
 	n, _, err = syscall.Recvfrom(hdl.fd, b, syscall.MSG_TRUNC)
@@ -164,17 +188,28 @@ func (hdl *handler) readFromIpv6(b []byte) (n int, iph *IpHeader, uh *UdpHeader,
 		return -1, nil, nil, nil, err
 	}
 
+	iph, uh, p, err = hdl.parseIpv6(b[:clampRecvLen(n, len(b))])
+	return n, iph, uh, p, err
+}
+
+// parseIpv6 parses a single already-received IPv6 datagram out of b. It
+// is the shared tail of readFromIpv6 and ReadBatch, which fills b via
+// recvmmsg(2) instead of Recvfrom.
+func (hdl *handler) parseIpv6(b []byte) (iph *IpHeader, uh *UdpHeader, p []byte, err error) {
+
+	n := len(b)
+
 	if b[0] != 0x60 { // ip version check
-		return n, nil, nil, nil, ErrNotIpv6
+		return nil, nil, nil, ErrNotIpv6
 	}
 
 	// ipheader
 	ipv6h, err := ipv6.ParseHeader(b)
 	if err != nil {
-		return n, nil, nil, nil, err
+		return nil, nil, nil, err
 	}
 
-	iph = &IpHeader{Ipv6Header: ipv6h}
+	iph = &IpHeader{Ipv6Header: ipv6h, Protocol: ipv6h.NextHeader}
 
 	startudp6h := ipv6.HeaderLen
 	endudp6h := startudp6h + UDPHeaderLen
@@ -182,24 +217,24 @@ func (hdl *handler) readFromIpv6(b []byte) (n int, iph *IpHeader, uh *UdpHeader,
 	// udp header
 	uh, err = ParseUDPHeader(b[startudp6h:])
 	if err != nil {
-		return n, iph, nil, nil, err
+		return iph, nil, nil, err
 	}
 
 	// payloadlen = udp header len + udp payload len
 	m := ipv6.HeaderLen + ipv6h.PayloadLen
 
 	if n != m {
-		return n, iph, nil, nil, ErrPayloadLen
+		return iph, nil, nil, ErrPayloadLen
 	}
 
 	pb := b[endudp6h:n]
 	// port
 	rcvport := hdl.sa.(*syscall.SockaddrInet6).Port
 	if rcvport != uh.DestinationPort {
-		return n, iph, uh, pb, ErrNotDestPort
+		return iph, uh, pb, ErrNotDestPort
 	}
 
-	return n, iph, uh, pb, nil
+	return iph, uh, pb, nil
 }
 
 // ===================
@@ -210,16 +245,42 @@ type Conn struct {
 	sa     syscall.Sockaddr
 	isIpv4 bool
 	handler
+
+	// file registers fd with the runtime poller (see
+	// packet_sock_dgram_deadline_linux.go) so reads can be cancelled by
+	// SetReadDeadline/ReadfromContext instead of blocking Close forever.
+	file    *os.File
+	rawConn syscall.RawConn
+
+	// ifIndex and dstHW are used by the send path (see
+	// packet_sock_dgram_write_linux.go) to fill in sockaddr_ll, since
+	// this module does no ARP/neighbor resolution of its own.
+	ifIndex int
+	dstHW   net.HardwareAddr
+
+	// ring is non-nil for Conns created by NewRingConn; Readfrom_ then
+	// walks the mmap'd TPACKET_V3 ring instead of calling Recvfrom.
+	ring *ring
+
+	// allProtocols and frag back ReadfromAny for Conns created by
+	// NewConnAllProtocols (see packet_sock_dgram_icmp_linux.go).
+	allProtocols bool
+	frag         *fragReassembler
 }
 
 func (c *Conn) Close() error {
 	if !c.handler.ok() {
 		return ErrInvalidConn
 	}
+	if c.ring != nil {
+		syscall.Munmap(c.ring.mem)
+	}
+	if c.file != nil {
+		return c.file.Close()
+	}
 	return syscall.Close(c.fd)
 }
 
-//
 func NewConn(sa syscall.Sockaddr, ifname string) (*Conn, error) {
 
 	isIpv4 := func(sa syscall.Sockaddr) bool {
@@ -246,6 +307,11 @@ func newConn(sa syscall.Sockaddr, isIpv4 bool, ifname string) (*Conn, error) {
 		}
 	}(isIpv4)
 
+	return newConnProto(sa, isIpv4, ifname, ethP)
+}
+
+func newConnProto(sa syscall.Sockaddr, isIpv4 bool, ifname string, ethP int) (*Conn, error) {
+
 	intf, err := net.InterfaceByName(ifname)
 
 	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, ethP)
@@ -265,17 +331,51 @@ func newConn(sa syscall.Sockaddr, isIpv4 bool, ifname string) (*Conn, error) {
 		return nil, err
 	}
 
+	f, err := newPollFile(fd, ifname)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
 	cnn := &Conn{
 		fd:      fd,
 		sa:      sa,
 		isIpv4:  isIpv4,
 		handler: handler{fd, sa, isIpv4},
+		file:    f,
+		rawConn: rc,
+		ifIndex: intf.Index,
+		dstHW:   net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
 	}
 	return cnn, nil
 }
 
+// Readfrom_ reads and parses a single datagram. When the fd is
+// registered with the runtime poller (always true for Conns created by
+// NewConn), it waits for readability through rawConn.Read, so a deadline
+// set with SetReadDeadline or an expired ReadfromContext unblocks it
+// instead of hanging forever on an idle socket.
 func (c *Conn) Readfrom_(b []byte) (n int, h *IpHeader, uh *UdpHeader, p []byte, err error) {
-	return c.handler.readfrom(b)
+	if c.ring != nil {
+		return c.readfromRing(b)
+	}
+	if c.rawConn == nil {
+		return c.handler.readfrom(b)
+	}
+
+	cerr := c.rawConn.Read(func(fd uintptr) bool {
+		n, h, uh, p, err = c.handler.readfrom(b)
+		return err != syscall.EAGAIN
+	})
+	if cerr != nil {
+		return n, h, uh, p, cerr
+	}
+	return n, h, uh, p, err
 }
 
-// ===================
\ No newline at end of file
+// ===================
@@ -0,0 +1,215 @@
+package packetsockdgram
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	ipv4DefaultTTL  = 64
+	ipv6DefaultHLim = 64
+)
+
+// SetDstHW sets the link-layer destination address used by WriteTo and
+// WriteRaw. This module does no ARP/neighbor discovery of its own, so
+// without a call to SetDstHW, writes go out to the broadcast address.
+func (c *Conn) SetDstHW(hw net.HardwareAddr) {
+	c.dstHW = hw
+}
+
+// WriteTo builds a UDP datagram (over IPv4 or IPv6, matching how c was
+// created) addressed to dst, wrapping it in an IP header sourced from
+// the address c was bound with, and sends it via WriteRaw.
+func (c *Conn) WriteTo(payload []byte, dst *net.UDPAddr) (int, error) {
+	if !c.handler.ok() {
+		return 0, ErrInvalidConn
+	}
+	if dst == nil {
+		return 0, ErrMissingAddress
+	}
+
+	srcPort, srcIP, err := c.localAddr()
+	if err != nil {
+		return 0, err
+	}
+
+	uh := &UdpHeader{
+		SourcePort:      srcPort,
+		DestinationPort: dst.Port,
+		Length:          UDPHeaderLen + len(payload),
+	}
+
+	iph := &IpHeader{}
+	if c.isIpv4 {
+		iph.Ipv4Header = &ipv4.Header{
+			Version:  4,
+			Len:      ipv4.HeaderLen,
+			TotalLen: ipv4.HeaderLen + UDPHeaderLen + len(payload),
+			TTL:      ipv4DefaultTTL,
+			Protocol: syscall.IPPROTO_UDP,
+			Src:      srcIP.To4(),
+			Dst:      dst.IP.To4(),
+		}
+	} else {
+		iph.Ipv6Header = &ipv6.Header{
+			Version:    6,
+			PayloadLen: UDPHeaderLen + len(payload),
+			NextHeader: syscall.IPPROTO_UDP,
+			HopLimit:   ipv6DefaultHLim,
+			Src:        srcIP.To16(),
+			Dst:        dst.IP.To16(),
+		}
+	}
+
+	return c.WriteRaw(iph, uh, payload)
+}
+
+// WriteRaw serializes iph+uh+payload into a single datagram and
+// sendto(2)s it over the AF_PACKET fd, targeting the interface c was
+// bound to via a sockaddr_ll built from c.ifIndex/c.dstHW.
+func (c *Conn) WriteRaw(iph *IpHeader, uh *UdpHeader, payload []byte) (int, error) {
+	if !c.handler.ok() {
+		return 0, ErrInvalidConn
+	}
+	if iph == nil || uh == nil {
+		return 0, ErrNilHeader
+	}
+
+	b, err := marshalDatagram(c.isIpv4, iph, uh, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), syscall.Sendto(c.fd, b, 0, c.sockaddrLL())
+}
+
+func (c *Conn) sockaddrLL() *syscall.SockaddrLinklayer {
+	sa := &syscall.SockaddrLinklayer{
+		Ifindex: c.ifIndex,
+	}
+	if c.isIpv4 {
+		sa.Protocol = htons(uint16(syscall.ETH_P_IP))
+	} else {
+		sa.Protocol = htons(uint16(syscall.ETH_P_IPV6))
+	}
+	sa.Halen = uint8(copy(sa.Addr[:], c.dstHW))
+	return sa
+}
+
+// localAddr returns the port/IP this Conn is logically bound to, taken
+// from the syscall.Sockaddr passed to NewConn.
+func (c *Conn) localAddr() (port int, ip net.IP, err error) {
+	switch sa := c.sa.(type) {
+	case *syscall.SockaddrInet4:
+		return sa.Port, net.IP(sa.Addr[:]), nil
+	case *syscall.SockaddrInet6:
+		return sa.Port, net.IP(sa.Addr[:]), nil
+	default:
+		return 0, nil, ErrInvalidConn
+	}
+}
+
+func marshalDatagram(isIpv4 bool, iph *IpHeader, uh *UdpHeader, payload []byte) ([]byte, error) {
+	udp := marshalUDP(uh, payload)
+
+	if isIpv4 {
+		if iph.Ipv4Header == nil {
+			return nil, ErrNilHeader
+		}
+		return marshalIPv4(iph.Ipv4Header, udp), nil
+	}
+	if iph.Ipv6Header == nil {
+		return nil, ErrNilHeader
+	}
+	return marshalIPv6(iph.Ipv6Header, udp), nil
+}
+
+func marshalUDP(uh *UdpHeader, payload []byte) []byte {
+	b := make([]byte, UDPHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(b[0:2], uint16(uh.SourcePort))
+	binary.BigEndian.PutUint16(b[2:4], uint16(uh.DestinationPort))
+	binary.BigEndian.PutUint16(b[4:6], uint16(uh.Length))
+	copy(b[8:], payload)
+	return b
+}
+
+// marshalIPv4 builds the IPv4 + UDP datagram, filling in the IPv4 header
+// checksum and the UDP checksum (computed over the IPv4 pseudo-header).
+func marshalIPv4(ih *ipv4.Header, udp []byte) []byte {
+	b := make([]byte, ipv4.HeaderLen+len(udp))
+	b[0] = 0x45 // version 4, IHL 5
+	b[1] = byte(ih.TOS)
+	binary.BigEndian.PutUint16(b[2:4], uint16(ih.TotalLen))
+	binary.BigEndian.PutUint16(b[4:6], uint16(ih.ID))
+	binary.BigEndian.PutUint16(b[6:8], 0) // flags/fragoff
+	b[8] = byte(ih.TTL)
+	b[9] = byte(ih.Protocol)
+	// checksum at b[10:12] filled in below
+	copy(b[12:16], ih.Src.To4())
+	copy(b[16:20], ih.Dst.To4())
+
+	binary.BigEndian.PutUint16(b[10:12], checksum(b[:ipv4.HeaderLen]))
+
+	copy(b[ipv4.HeaderLen:], udp)
+	binary.BigEndian.PutUint16(b[ipv4.HeaderLen+6:ipv4.HeaderLen+8],
+		udpChecksum(ih.Src.To4(), ih.Dst.To4(), ih.Protocol, b[ipv4.HeaderLen:]))
+
+	return b
+}
+
+func marshalIPv6(ih *ipv6.Header, udp []byte) []byte {
+	b := make([]byte, ipv6.HeaderLen+len(udp))
+	b[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(b[4:6], uint16(ih.PayloadLen))
+	b[6] = byte(ih.NextHeader)
+	b[7] = byte(ih.HopLimit)
+	copy(b[8:24], ih.Src.To16())
+	copy(b[24:40], ih.Dst.To16())
+
+	copy(b[ipv6.HeaderLen:], udp)
+	binary.BigEndian.PutUint16(b[ipv6.HeaderLen+6:ipv6.HeaderLen+8],
+		udpChecksum(ih.Src.To16(), ih.Dst.To16(), ih.NextHeader, b[ipv6.HeaderLen:]))
+
+	return b
+}
+
+// checksum computes the one's-complement Internet checksum (RFC 1071).
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i : i+2]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// udpChecksum computes the UDP checksum over the pseudo-header (RFC 768
+// for IPv4, RFC 8200 §8.1 for IPv6) followed by the UDP segment itself.
+func udpChecksum(src, dst net.IP, proto int, udp []byte) uint16 {
+	pseudo := make([]byte, 0, len(src)+len(dst)+8)
+	pseudo = append(pseudo, src...)
+	pseudo = append(pseudo, dst...)
+	if len(src) == 4 {
+		pseudo = append(pseudo, 0, byte(proto))
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(udp)))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(udp)))
+		pseudo = append(pseudo, 0, 0, 0, byte(proto))
+	}
+	full := append(pseudo, udp...)
+
+	sum := checksum(full)
+	if sum == 0 {
+		return 0xffff
+	}
+	return sum
+}
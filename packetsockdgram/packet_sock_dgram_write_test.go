@@ -0,0 +1,75 @@
+package packetsockdgram
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestChecksum(t *testing.T) {
+	cases := []struct {
+		name string
+		b    []byte
+		want uint16
+	}{
+		{"all zero", []byte{0x00, 0x00}, 0xffff},
+		{"all one", []byte{0xff, 0xff}, 0x0000},
+		{"odd length pads with zero byte", []byte{0xff}, 0x00ff},
+		{"carry folds back in", []byte{0xff, 0xff, 0xff, 0xff, 0x00, 0x03}, 0xfffc},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := checksum(c.b); got != c.want {
+				t.Fatalf("checksum(%x) = %#x, want %#x", c.b, got, c.want)
+			}
+		})
+	}
+}
+
+// A correctly computed Internet checksum folds such that recomputing the
+// checksum over the data with the checksum field already filled in
+// yields zero (RFC 1071 §4.1). marshalIPv4/marshalUDP rely on this.
+func TestMarshalIPv4ChecksumSelfVerifies(t *testing.T) {
+	ih := &ipv4.Header{
+		TTL:      64,
+		Protocol: syscall.IPPROTO_UDP,
+		Src:      net.IPv4(192, 168, 0, 1).To4(),
+		Dst:      net.IPv4(192, 168, 0, 199).To4(),
+	}
+	uh := &UdpHeader{SourcePort: 1234, DestinationPort: 5678, Length: UDPHeaderLen + 4}
+
+	b := marshalIPv4(ih, marshalUDP(uh, []byte("ping")))
+
+	if got := checksum(b[:ipv4.HeaderLen]); got != 0 {
+		t.Fatalf("recomputed IPv4 header checksum = %#x, want 0", got)
+	}
+
+	// udpChecksum maps a genuinely-zero sum to 0xffff (0 being IPv4
+	// UDP's "no checksum" sentinel), so verify via checksum() directly over
+	// the pseudo-header + UDP segment instead of calling udpChecksum
+	// again, which would mask that self-verifying zero.
+	udp := b[ipv4.HeaderLen:]
+	pseudo := append(append(append([]byte{}, ih.Src.To4()...), ih.Dst.To4()...), 0, byte(ih.Protocol))
+	pseudo = append(pseudo, byte(len(udp)>>8), byte(len(udp)))
+	if got := checksum(append(pseudo, udp...)); got != 0 {
+		t.Fatalf("recomputed UDP checksum = %#x, want 0", got)
+	}
+}
+
+func TestMarshalUDPFields(t *testing.T) {
+	uh := &UdpHeader{SourcePort: 1, DestinationPort: 2, Length: UDPHeaderLen + 3}
+	b := marshalUDP(uh, []byte("abc"))
+
+	got, err := ParseUDPHeader(b)
+	if err != nil {
+		t.Fatalf("ParseUDPHeader: %v", err)
+	}
+	if got.SourcePort != 1 || got.DestinationPort != 2 || got.Length != UDPHeaderLen+3 {
+		t.Fatalf("round-tripped header = %+v, want src=1 dst=2 len=%d", got, UDPHeaderLen+3)
+	}
+	if string(b[UDPHeaderLen:]) != "abc" {
+		t.Fatalf("payload = %q, want %q", b[UDPHeaderLen:], "abc")
+	}
+}